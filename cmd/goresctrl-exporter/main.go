@@ -0,0 +1,68 @@
+/*
+Copyright 2022 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command goresctrl-exporter periodically samples RDT monitoring data and
+// serves it as Prometheus metrics, so that users of pkg/rdt don't have to
+// write their own polling loop just to get /metrics.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/marquiz/goresctrl/pkg/rdt"
+)
+
+func main() {
+	groupPrefix := flag.String("group-prefix", "", "resctrl group prefix to use")
+	config := flag.String("config", "", "path to the RDT YAML configuration file; watched for live reload if set")
+	interval := flag.Duration("interval", 10*time.Second, "monitoring sample interval")
+	listenAddress := flag.String("listen-address", ":9692", "address to serve /metrics on")
+	flag.Parse()
+
+	if err := rdt.Initialize(*groupPrefix); err != nil {
+		log.Fatalf("failed to initialize rdt: %v", err)
+	}
+
+	if *config != "" {
+		if err := rdt.WatchConfig(*config); err != nil {
+			log.Fatalf("failed to watch config %q: %v", *config, err)
+		}
+		go logConfigEvents()
+	}
+
+	collector := rdt.NewCollector(*interval)
+	collector.Start()
+	defer collector.Stop()
+
+	http.Handle("/metrics", collector)
+	log.Printf("serving RDT monitoring metrics on %s/metrics", *listenAddress)
+	if err := http.ListenAndServe(*listenAddress, nil); err != nil {
+		log.Fatalf("exporter failed: %v", err)
+	}
+}
+
+func logConfigEvents() {
+	for ev := range rdt.ConfigEvents() {
+		if ev.Type == rdt.ConfigEventInvalid {
+			log.Printf("config %q failed to apply: %v", ev.Path, ev.Err)
+			continue
+		}
+		log.Printf("config %q reloaded", ev.Path)
+	}
+}
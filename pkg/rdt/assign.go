@@ -0,0 +1,347 @@
+/*
+Copyright 2021 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Assignment describes the RDT class (and, optionally, monitoring group)
+// that a container's cgroup has been assigned to by a ContainerAssigner.
+type Assignment struct {
+	CgroupPath string
+	Class      string
+	MonGroup   string
+}
+
+// pidAdder is the subset of CtrlGroup/MonGroup that ContainerAssigner needs
+// in order to move tasks into either one.
+type pidAdder interface {
+	AddPids(pids ...string) error
+}
+
+// ContainerAssigner resolves all tasks (processes and threads) of a
+// container's cgroup to an RDT class, instead of requiring callers to
+// enumerate pids themselves and call CtrlGroup.AddPids directly. It is the
+// missing glue between raw pid-level assignment and real container
+// runtimes, which only know a container's id and cgroup path.
+type ContainerAssigner struct {
+	mu          sync.Mutex
+	assignments map[string]*containerAssignment
+	persistPath string
+	interval    time.Duration
+}
+
+type containerAssignment struct {
+	Assignment
+	stop chan struct{}
+}
+
+// NewContainerAssigner creates a ContainerAssigner that persists its
+// assignments as JSON to persistPath and, if interval is non-zero, runs a
+// background watcher per container that re-syncs newly forked pids into its
+// class every interval until the container is unassigned. persistPath may
+// be empty to disable persistence.
+func NewContainerAssigner(persistPath string, interval time.Duration) *ContainerAssigner {
+	a := &ContainerAssigner{
+		assignments: make(map[string]*containerAssignment),
+		persistPath: persistPath,
+		interval:    interval,
+	}
+	a.load()
+	return a
+}
+
+// Assign moves every task currently in the cgroup at cgroupPath into the
+// RDT class className, optionally creating (or reusing) monGroupName as its
+// monitoring group, and starts tracking containerID so that its tasks stay
+// in sync as new ones fork.
+func (a *ContainerAssigner) Assign(containerID, cgroupPath, className, monGroupName string) error {
+	cls, ok := GetClass(className)
+	if !ok {
+		return fmt.Errorf("unknown RDT class %q", className)
+	}
+
+	var dst pidAdder = cls
+	if monGroupName != "" {
+		mg, ok := cls.GetMonGroup(monGroupName)
+		if !ok {
+			var err error
+			mg, err = cls.CreateMonGroup(monGroupName, nil)
+			if err != nil {
+				return fmt.Errorf("failed to create mon group %q for container %q: %w", monGroupName, containerID, err)
+			}
+		}
+		dst = mg
+	}
+
+	if err := assignCgroupTasks(cgroupPath, dst); err != nil {
+		return fmt.Errorf("failed to assign container %q to class %q: %w", containerID, className, err)
+	}
+
+	a.mu.Lock()
+	if existing, ok := a.assignments[containerID]; ok {
+		close(existing.stop)
+	}
+	ca := &containerAssignment{
+		Assignment: Assignment{CgroupPath: cgroupPath, Class: className, MonGroup: monGroupName},
+		stop:       make(chan struct{}),
+	}
+	a.assignments[containerID] = ca
+	a.save()
+	a.mu.Unlock()
+
+	if a.interval > 0 {
+		go a.watch(cgroupPath, dst, ca.stop)
+	}
+	return nil
+}
+
+// Unassign stops tracking containerID, leaving its tasks in whatever class
+// they were last moved to.
+func (a *ContainerAssigner) Unassign(containerID string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ca, ok := a.assignments[containerID]
+	if !ok {
+		return fmt.Errorf("no assignment known for container %q", containerID)
+	}
+	close(ca.stop)
+	delete(a.assignments, containerID)
+	return a.save()
+}
+
+// List returns the current assignment of every tracked container.
+func (a *ContainerAssigner) List() map[string]Assignment {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := make(map[string]Assignment, len(a.assignments))
+	for id, ca := range a.assignments {
+		out[id] = ca.Assignment
+	}
+	return out
+}
+
+// Reconcile re-applies every assignment loaded from persistPath against the
+// current state of /proc and /sys/fs/resctrl. It is meant to be called once
+// after Initialize: assignments whose cgroup no longer exists (the
+// container exited while we were down) are dropped, the rest have their
+// current tasks re-synced into their class/mon group and their background
+// watcher (re-)started.
+func (a *ContainerAssigner) Reconcile() {
+	a.mu.Lock()
+	pending := make(map[string]Assignment, len(a.assignments))
+	for id, ca := range a.assignments {
+		if _, err := os.Stat(ca.CgroupPath); err != nil {
+			delete(a.assignments, id)
+			continue
+		}
+		pending[id] = ca.Assignment
+	}
+	a.save()
+	a.mu.Unlock()
+
+	for id, asg := range pending {
+		// Best effort: an assignment whose class no longer exists in the
+		// current config, or whose container exited in the meantime, is
+		// dropped here, and from persistPath on the next successful
+		// Assign/Unassign.
+		if err := a.Assign(id, asg.CgroupPath, asg.Class, asg.MonGroup); err != nil {
+			a.mu.Lock()
+			delete(a.assignments, id)
+			a.mu.Unlock()
+		}
+	}
+}
+
+// watch periodically re-reads cgroupPath's tasks and adds all of them to
+// dst again, so that processes/threads forked after Assign was called
+// still land in the right class.
+func (a *ContainerAssigner) watch(cgroupPath string, dst pidAdder, stop <-chan struct{}) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// Best effort: the container may be in the middle of exiting,
+			// in which case the next tick (or Unassign) will settle things.
+			_ = assignCgroupTasks(cgroupPath, dst)
+		}
+	}
+}
+
+// save writes every tracked assignment to persistPath. It does not take
+// a.mu itself: Assign, Unassign and Reconcile call it with a.mu held.
+func (a *ContainerAssigner) save() error {
+	if a.persistPath == "" {
+		return nil
+	}
+	out := make(map[string]Assignment, len(a.assignments))
+	for id, ca := range a.assignments {
+		out[id] = ca.Assignment
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(a.persistPath, data, 0644)
+}
+
+func (a *ContainerAssigner) load() {
+	if a.persistPath == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(a.persistPath)
+	if err != nil {
+		return
+	}
+	var assignments map[string]Assignment
+	if err := json.Unmarshal(data, &assignments); err != nil {
+		return
+	}
+	for id, asg := range assignments {
+		a.assignments[id] = &containerAssignment{Assignment: asg, stop: make(chan struct{})}
+	}
+}
+
+// assignCgroupTasks reads every task under cgroupPath and adds it to dst,
+// retrying tasks individually if some of them exited (ESRCH) between being
+// listed and being moved.
+func assignCgroupTasks(cgroupPath string, dst pidAdder) error {
+	pids, err := cgroupTasks(cgroupPath)
+	if err != nil {
+		return err
+	}
+	if len(pids) == 0 {
+		return nil
+	}
+
+	if err := dst.AddPids(pids...); err != nil {
+		if !isESRCH(err) {
+			return err
+		}
+		for _, pid := range pids {
+			if err := dst.AddPids(pid); err != nil && !isESRCH(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// cgroupTasks recursively collects every thread id under cgroupPath: it
+// reads the process ids directly in cgroupPath and every nested cgroup
+// (cgroup.procs on v2, tasks on v1), then expands each process id to its
+// full set of thread ids via /proc/<pid>/task, the way containerd walks a
+// container's full pid set rather than just its top-level processes.
+func cgroupTasks(cgroupPath string) ([]string, error) {
+	pids, err := cgroupProcs(cgroupPath)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := ioutil.ReadDir(cgroupPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			sub, err := cgroupTasks(filepath.Join(cgroupPath, e.Name()))
+			if err != nil {
+				return nil, err
+			}
+			pids = append(pids, sub...)
+		}
+	}
+
+	seen := make(map[string]struct{}, len(pids))
+	tids := make([]string, 0, len(pids))
+	for _, pid := range pids {
+		threads, err := processThreadsFunc(pid)
+		if err != nil {
+			// The process exited before we could list its threads; skip it.
+			continue
+		}
+		for _, tid := range threads {
+			if _, ok := seen[tid]; !ok {
+				seen[tid] = struct{}{}
+				tids = append(tids, tid)
+			}
+		}
+	}
+	return tids, nil
+}
+
+// cgroupProcs reads the process ids directly in cgroupPath, preferring the
+// cgroup v2 cgroup.procs file and falling back to the cgroup v1 tasks file.
+func cgroupProcs(cgroupPath string) ([]string, error) {
+	for _, name := range []string{"cgroup.procs", "tasks"} {
+		data, err := ioutil.ReadFile(filepath.Join(cgroupPath, name))
+		if err == nil {
+			return splitLines(data), nil
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("neither cgroup.procs nor tasks found in %q", cgroupPath)
+}
+
+// processThreadsFunc returns the thread ids of a process id; it is a
+// variable so tests can stub out /proc access deterministically.
+var processThreadsFunc = processThreads
+
+// processThreads returns the thread ids of pid, read from /proc/<pid>/task.
+func processThreads(pid string) ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join("/proc", pid, "task"))
+	if err != nil {
+		return nil, err
+	}
+	tids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		tids = append(tids, e.Name())
+	}
+	return tids, nil
+}
+
+func splitLines(data []byte) []string {
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	out := make([]string, 0, len(lines))
+	for _, l := range lines {
+		if l != "" {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// isESRCH reports whether err (possibly wrapped, e.g. by fs.OpenFile/Write)
+// came from a task that exited between being listed and being moved into a
+// new class.
+func isESRCH(err error) bool {
+	return errors.Is(err, syscall.ESRCH)
+}
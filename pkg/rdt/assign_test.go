@@ -0,0 +1,200 @@
+/*
+Copyright 2021 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdt
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCgroupProcs(t *testing.T) {
+	dir := t.TempDir()
+
+	// cgroup.procs (cgroup v2) takes precedence over tasks (cgroup v1)
+	if err := ioutil.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte("10\n11\n"), 0644); err != nil {
+		t.Fatalf("failed to write mock cgroup.procs: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "tasks"), []byte("10\n11\n12\n"), 0644); err != nil {
+		t.Fatalf("failed to write mock tasks: %v", err)
+	}
+
+	pids, err := cgroupProcs(dir)
+	if err != nil {
+		t.Fatalf("cgroupProcs() failed: %v", err)
+	}
+	if !cmp.Equal(pids, []string{"10", "11"}) {
+		t.Errorf("cgroupProcs() returned %v, expected %v", pids, []string{"10", "11"})
+	}
+
+	// Falls back to tasks when cgroup.procs is absent
+	dirV1 := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dirV1, "tasks"), []byte("20\n21\n"), 0644); err != nil {
+		t.Fatalf("failed to write mock tasks: %v", err)
+	}
+	pids, err = cgroupProcs(dirV1)
+	if err != nil {
+		t.Fatalf("cgroupProcs() failed: %v", err)
+	}
+	if !cmp.Equal(pids, []string{"20", "21"}) {
+		t.Errorf("cgroupProcs() returned %v, expected %v", pids, []string{"20", "21"})
+	}
+
+	if _, err := cgroupProcs(t.TempDir()); err == nil {
+		t.Errorf("expected error for cgroup with neither cgroup.procs nor tasks")
+	}
+}
+
+// TestAssignCgroupTasksIntegration verifies that assignCgroupTasks (and
+// therefore ContainerAssigner.Assign) actually moves every task of a cgroup
+// into a real CtrlGroup's tasks file, against a mock resctrl filesystem.
+func TestAssignCgroupTasksIntegration(t *testing.T) {
+	origThreads := processThreadsFunc
+	defer func() { processThreadsFunc = origThreads }()
+	processThreadsFunc = func(pid string) ([]string, error) {
+		return []string{pid + "-t1", pid + "-t2"}, nil
+	}
+
+	mockFs, err := newMockResctrlFs(t, "resctrl.full", "")
+	if err != nil {
+		t.Fatalf("failed to set up mock resctrl fs: %v", err)
+	}
+	defer mockFs.delete()
+	groupRemoveFunc = func(path string) error { return fs.RemoveAll(path) }
+
+	if err := Initialize(mockGroupPrefix); err != nil {
+		t.Fatalf("rdt initialization failed: %v", err)
+	}
+
+	// Use a fresh, test-owned class directory rather than an existing
+	// fixture class, so the tasks file is guaranteed empty beforehand.
+	cls := &ctrlGroup{name: "test-class", dirName: "test-class", monGroups: make(map[string]*monGroup)}
+	if err := fs.MkdirAll(cls.path(), 0755); err != nil {
+		t.Fatalf("failed to create test class directory: %v", err)
+	}
+
+	cgroupDir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(cgroupDir, "cgroup.procs"), []byte("100\n101\n"), 0644); err != nil {
+		t.Fatalf("failed to write mock cgroup.procs: %v", err)
+	}
+
+	if err := assignCgroupTasks(cgroupDir, cls); err != nil {
+		t.Fatalf("assignCgroupTasks() failed: %v", err)
+	}
+
+	got, err := cls.GetPids()
+	if err != nil {
+		t.Fatalf("GetPids() failed: %v", err)
+	}
+	want := []string{"100-t1", "100-t2", "101-t1", "101-t2"}
+	if !cmp.Equal(got, want) {
+		t.Errorf("GetPids() returned %v, expected %v", got, want)
+	}
+}
+
+// fakePidAdder is a pidAdder whose AddPids behavior is fully scripted, used
+// to deterministically exercise assignCgroupTasks' ESRCH retry path without
+// depending on real processes exiting at the right moment.
+type fakePidAdder struct {
+	calls          [][]string
+	bulkErr        error
+	individualErrs map[string]error
+}
+
+func (f *fakePidAdder) AddPids(pids ...string) error {
+	f.calls = append(f.calls, append([]string{}, pids...))
+	if len(pids) > 1 {
+		return f.bulkErr
+	}
+	if err, ok := f.individualErrs[pids[0]]; ok {
+		return err
+	}
+	return nil
+}
+
+func TestAssignCgroupTasksESRCHRetry(t *testing.T) {
+	origThreads := processThreadsFunc
+	defer func() { processThreadsFunc = origThreads }()
+	processThreadsFunc = func(pid string) ([]string, error) { return []string{pid}, nil }
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte("10\n11\n12\n"), 0644); err != nil {
+		t.Fatalf("failed to write mock cgroup.procs: %v", err)
+	}
+
+	adder := &fakePidAdder{
+		bulkErr: fmt.Errorf("process vanished: %w", syscall.ESRCH),
+		individualErrs: map[string]error{
+			"11": fmt.Errorf("process vanished: %w", syscall.ESRCH),
+		},
+	}
+	if err := assignCgroupTasks(dir, adder); err != nil {
+		t.Fatalf("assignCgroupTasks() failed: %v", err)
+	}
+
+	want := [][]string{{"10", "11", "12"}, {"10"}, {"11"}, {"12"}}
+	if !cmp.Equal(adder.calls, want) {
+		t.Errorf("unexpected AddPids() calls %v, expected %v", adder.calls, want)
+	}
+}
+
+func TestAssignCgroupTasksNonESRCHError(t *testing.T) {
+	origThreads := processThreadsFunc
+	defer func() { processThreadsFunc = origThreads }()
+	processThreadsFunc = func(pid string) ([]string, error) { return []string{pid}, nil }
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte("10\n11\n"), 0644); err != nil {
+		t.Fatalf("failed to write mock cgroup.procs: %v", err)
+	}
+
+	wantErr := errors.New("permission denied")
+	adder := &fakePidAdder{bulkErr: wantErr}
+	if err := assignCgroupTasks(dir, adder); !errors.Is(err, wantErr) {
+		t.Fatalf("assignCgroupTasks() returned %v, expected it to wrap %v", err, wantErr)
+	}
+	if len(adder.calls) != 1 {
+		t.Errorf("expected assignCgroupTasks() to give up after the bulk AddPids() call, got %d calls", len(adder.calls))
+	}
+}
+
+func TestContainerAssignerPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "assignments.json")
+
+	a := NewContainerAssigner(path, 0)
+	a.assignments["c1"] = &containerAssignment{
+		Assignment: Assignment{CgroupPath: "/sys/fs/cgroup/c1", Class: "Guaranteed", MonGroup: "mg1"},
+		stop:       make(chan struct{}),
+	}
+	if err := a.save(); err != nil {
+		t.Fatalf("save() failed: %v", err)
+	}
+
+	b := NewContainerAssigner(path, 0)
+	got := b.List()
+	want := map[string]Assignment{
+		"c1": {CgroupPath: "/sys/fs/cgroup/c1", Class: "Guaranteed", MonGroup: "mg1"},
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("List() after reload returned %v, expected %v", got, want)
+	}
+}
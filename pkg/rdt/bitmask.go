@@ -0,0 +1,118 @@
+/*
+Copyright 2019 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdt
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Bitmask represents a generic 64 bit wide bitmask, e.g. a cache capacity
+// bitmask (CBM).
+type Bitmask uint64
+
+// ListStr returns the bitmask as a human readable, comma-separated list of
+// set bit numbers/ranges, e.g. Bitmask(0x1d1a).ListStr() == "1,3-4,8,10-12".
+func (b Bitmask) ListStr() string {
+	var parts []string
+
+	start := -1
+	for i := 0; i <= 64; i++ {
+		set := i < 64 && b&(1<<uint(i)) != 0
+		switch {
+		case set && start < 0:
+			start = i
+		case !set && start >= 0:
+			if i-1 == start {
+				parts = append(parts, strconv.Itoa(start))
+			} else {
+				parts = append(parts, fmt.Sprintf("%d-%d", start, i-1))
+			}
+			start = -1
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// ListStrToBitmask parses a comma-separated list of bit numbers and/or
+// ranges (the format produced by Bitmask.ListStr) into a Bitmask.
+func ListStrToBitmask(s string) (Bitmask, error) {
+	ids, err := listStrToArray(s)
+	if err != nil {
+		return 0, err
+	}
+
+	var b Bitmask
+	for _, id := range ids {
+		if id >= 64 {
+			return 0, fmt.Errorf("bit number %d out of range for a 64 bit mask", id)
+		}
+		b |= 1 << uint(id)
+	}
+	return b, nil
+}
+
+// listStrToArray parses a comma-separated list of non-negative integers
+// and/or ranges ("a-b") into a sorted, de-duplicated slice of ints.
+func listStrToArray(s string) ([]int, error) {
+	if s == "" {
+		return []int{}, nil
+	}
+
+	seen := make(map[int]struct{})
+	for _, part := range strings.Split(s, ",") {
+		if part == "" {
+			return nil, fmt.Errorf("invalid (empty) list item in %q", s)
+		}
+
+		if idx := strings.IndexByte(part, '-'); idx > 0 {
+			lo, err := strconv.Atoi(part[:idx])
+			if err != nil {
+				return nil, fmt.Errorf("invalid range %q: %w", part, err)
+			}
+			hi, err := strconv.Atoi(part[idx+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid range %q: %w", part, err)
+			}
+			if hi <= lo {
+				return nil, fmt.Errorf("invalid range %q: end must be greater than start", part)
+			}
+			for i := lo; i <= hi; i++ {
+				seen[i] = struct{}{}
+			}
+			continue
+		}
+
+		v, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid list item %q: %w", part, err)
+		}
+		if v < 0 {
+			return nil, fmt.Errorf("invalid list item %q: must not be negative", part)
+		}
+		seen[v] = struct{}{}
+	}
+
+	out := make([]int, 0, len(seen))
+	for v := range seen {
+		out = append(out, v)
+	}
+	sort.Ints(out)
+	return out, nil
+}
@@ -0,0 +1,370 @@
+/*
+Copyright 2022 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdt
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// L3Sample is one monitoring group's reading for a single L3 cache id:
+// the raw LLC occupancy and the local/total memory bandwidth, smoothed
+// over a rolling window of samples.
+type L3Sample struct {
+	LLCOccupancy uint64
+	LocalBWBytes float64 // bytes/sec
+	TotalBWBytes float64 // bytes/sec
+}
+
+// Sample is one periodic reading of a monitoring group, published on the
+// channel returned by Collector.Samples and exposed via Collector's
+// http.Handler.
+type Sample struct {
+	Class       string
+	MonGroup    string
+	Annotations map[string]string
+	Timestamp   time.Time
+	L3          map[int]L3Sample
+}
+
+// CollectorOption configures optional behavior of a Collector.
+type CollectorOption func(*Collector)
+
+// WithCounterWidth sets the bit width that the mbm_local_bytes and
+// mbm_total_bytes counters wrap around at. It defaults to 64 (no
+// wraparound within any realistic sampling interval); set it to 32 on
+// hardware whose monitoring counters are known to wrap at that width.
+func WithCounterWidth(width uint) CollectorOption {
+	return func(c *Collector) { c.counterWidth = width }
+}
+
+// WithWindow sets the number of samples that bandwidth rates are averaged
+// over. It defaults to 4.
+func WithWindow(n int) CollectorOption {
+	return func(c *Collector) { c.window = n }
+}
+
+// Collector periodically samples every monitoring group's MonData and
+// turns the monotonically increasing mbm_local_bytes/mbm_total_bytes
+// counters into smoothed bytes/sec rates, publishing the result both on a
+// Go channel and, as Prometheus text-format metrics, via its http.Handler
+// implementation. It replaces having every caller of GetMonData write its
+// own polling loop and rate calculation.
+type Collector struct {
+	interval     time.Duration
+	counterWidth uint
+	window       int
+
+	mu    sync.Mutex
+	state map[monGroupKey]*monGroupState
+	last  map[monGroupKey]Sample
+
+	samples chan Sample
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+type monGroupKey struct {
+	class string
+	group string
+}
+
+type monGroupState struct {
+	t    time.Time
+	raw  MonL3Data
+	rate map[int]*l3Rates
+}
+
+type l3Rates struct {
+	local *rollingAvg
+	total *rollingAvg
+}
+
+// NewCollector creates a Collector that samples every monitoring group
+// every interval. Start begins sampling in the background; Stop ends it.
+func NewCollector(interval time.Duration, opts ...CollectorOption) *Collector {
+	c := &Collector{
+		interval:     interval,
+		counterWidth: 64,
+		window:       4,
+		state:        make(map[monGroupKey]*monGroupState),
+		last:         make(map[monGroupKey]Sample),
+		samples:      make(chan Sample, 16),
+		stop:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Samples returns the channel on which the Collector publishes a Sample for
+// every monitoring group on every sampling interval. It is closed when Stop
+// is called.
+func (c *Collector) Samples() <-chan Sample {
+	return c.samples
+}
+
+// Start begins periodic sampling in the background.
+func (c *Collector) Start() {
+	c.wg.Add(1)
+	go c.run()
+}
+
+// Stop ends periodic sampling and closes the channel returned by Samples.
+func (c *Collector) Stop() {
+	close(c.stop)
+	c.wg.Wait()
+}
+
+func (c *Collector) run() {
+	defer c.wg.Done()
+	defer close(c.samples)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case now := <-ticker.C:
+			c.collect(now)
+		}
+	}
+}
+
+func (c *Collector) collect(now time.Time) {
+	live := make(map[monGroupKey]struct{})
+	for _, cls := range GetClasses() {
+		for _, mg := range cls.GetMonGroups() {
+			key := monGroupKey{class: cls.Name(), group: mg.Name()}
+			live[key] = struct{}{}
+
+			sample := c.sampleMonGroup(key, mg, now)
+			select {
+			case c.samples <- sample:
+			default:
+				// Don't block the sampling loop on a slow or absent reader;
+				// the next tick's sample supersedes this one anyway.
+			}
+		}
+	}
+	c.evictStale(live)
+}
+
+// evictStale removes any class/mon group from c.state and c.last that is no
+// longer present in live, so a class or mon group that disappears (e.g. via
+// a config reload) stops being served as a stale Prometheus series and its
+// counter state is freed instead of growing unbounded.
+func (c *Collector) evictStale(live map[monGroupKey]struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.state {
+		if _, ok := live[key]; !ok {
+			delete(c.state, key)
+		}
+	}
+	for key := range c.last {
+		if _, ok := live[key]; !ok {
+			delete(c.last, key)
+		}
+	}
+}
+
+func (c *Collector) sampleMonGroup(key monGroupKey, mg MonGroup, now time.Time) Sample {
+	data := mg.GetMonData()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state, ok := c.state[key]
+	if !ok {
+		state = &monGroupState{rate: make(map[int]*l3Rates)}
+		c.state[key] = state
+	}
+
+	sample := Sample{
+		Class:       key.class,
+		MonGroup:    mg.Name(),
+		Annotations: mg.GetAnnotations(),
+		Timestamp:   now,
+		L3:          make(map[int]L3Sample, len(data.L3)),
+	}
+
+	elapsed := now.Sub(state.t).Seconds()
+	for id, leaf := range data.L3 {
+		rates, ok := state.rate[id]
+		if !ok {
+			rates = &l3Rates{local: newRollingAvg(c.window), total: newRollingAvg(c.window)}
+			state.rate[id] = rates
+		}
+
+		var localBW, totalBW float64
+		if prevLeaf, ok := state.raw[id]; ok && elapsed > 0 {
+			localDelta := counterDelta(prevLeaf["mbm_local_bytes"], leaf["mbm_local_bytes"], c.counterWidth)
+			totalDelta := counterDelta(prevLeaf["mbm_total_bytes"], leaf["mbm_total_bytes"], c.counterWidth)
+			localBW = rates.local.add(float64(localDelta) / elapsed)
+			totalBW = rates.total.add(float64(totalDelta) / elapsed)
+		}
+
+		sample.L3[id] = L3Sample{
+			LLCOccupancy: leaf["llc_occupancy"],
+			LocalBWBytes: localBW,
+			TotalBWBytes: totalBW,
+		}
+	}
+
+	state.t = now
+	state.raw = data.L3
+	c.last[key] = sample
+
+	return sample
+}
+
+// ServeHTTP implements http.Handler, serving the most recently collected
+// sample of every monitoring group as Prometheus text-format metrics,
+// labeled with class, mon_group, l3_cache_id and the mon group's
+// annotations.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	samples := make([]Sample, 0, len(c.last))
+	for _, s := range c.last {
+		samples = append(samples, s)
+	}
+	c.mu.Unlock()
+
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].Class != samples[j].Class {
+			return samples[i].Class < samples[j].Class
+		}
+		return samples[i].MonGroup < samples[j].MonGroup
+	})
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	writeMetric := func(name, help string, value func(L3Sample) string) {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+		for _, s := range samples {
+			annotationLabels := formatAnnotationLabels(s.Annotations)
+			for id, l3 := range s.L3 {
+				fmt.Fprintf(w, "%s{class=%q,mon_group=%q,l3_cache_id=%q%s} %s\n",
+					name, s.Class, s.MonGroup, strconv.Itoa(id), annotationLabels, value(l3))
+			}
+		}
+	}
+
+	writeMetric("goresctrl_mbm_local_bytes_per_second", "Smoothed local memory bandwidth.",
+		func(l3 L3Sample) string { return strconv.FormatFloat(l3.LocalBWBytes, 'g', -1, 64) })
+	writeMetric("goresctrl_mbm_total_bytes_per_second", "Smoothed total memory bandwidth.",
+		func(l3 L3Sample) string { return strconv.FormatFloat(l3.TotalBWBytes, 'g', -1, 64) })
+	writeMetric("goresctrl_llc_occupancy_bytes", "Last polled LLC occupancy.",
+		func(l3 L3Sample) string { return strconv.FormatUint(l3.LLCOccupancy, 10) })
+}
+
+// formatAnnotationLabels renders a's entries as additional Prometheus label
+// assignments (each preceded by a comma, ready to splice into an existing
+// label list), one label per annotation key, so annotations stay queryable
+// and groupable individually instead of being flattened into one opaque
+// string value.
+func formatAnnotationLabels(a map[string]string) string {
+	if len(a) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(a))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, ",%s=%q", sanitizeLabelName(k), a[k])
+	}
+	return b.String()
+}
+
+// sanitizeLabelName turns an arbitrary annotation key into a valid
+// Prometheus label name ([a-zA-Z_][a-zA-Z0-9_]*), replacing every invalid
+// character with an underscore and guarding against a leading digit.
+func sanitizeLabelName(k string) string {
+	var b strings.Builder
+	for i, r := range k {
+		switch {
+		case r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z'):
+			b.WriteRune(r)
+		case r >= '0' && r <= '9' && i > 0:
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// counterDelta returns the forward difference between two readings of a
+// monotonically increasing counter that is width bits wide, correctly
+// handling a single wraparound between the two reads.
+func counterDelta(prev, cur uint64, width uint) uint64 {
+	if cur >= prev {
+		return cur - prev
+	}
+	if width >= 64 {
+		return cur - prev // uint64 subtraction already wraps mod 2^64
+	}
+	return (uint64(1)<<width - prev) + cur
+}
+
+// rollingAvg is a fixed-size rolling average of the last N values added to
+// it, used to smooth bandwidth rates derived from noisy per-interval
+// counter deltas.
+type rollingAvg struct {
+	values []float64
+	n      int // number of valid entries in values, capped at len(values)
+	next   int // index the next add() overwrites
+}
+
+func newRollingAvg(window int) *rollingAvg {
+	if window < 1 {
+		window = 1
+	}
+	return &rollingAvg{values: make([]float64, window)}
+}
+
+// add records v and returns the average of the window's current contents.
+func (r *rollingAvg) add(v float64) float64 {
+	r.values[r.next] = v
+	r.next = (r.next + 1) % len(r.values)
+	if r.n < len(r.values) {
+		r.n++
+	}
+
+	sum := 0.0
+	for i := 0; i < r.n; i++ {
+		sum += r.values[i]
+	}
+	return sum / float64(r.n)
+}
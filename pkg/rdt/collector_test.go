@@ -0,0 +1,83 @@
+/*
+Copyright 2022 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdt
+
+import "testing"
+
+func TestCounterDelta(t *testing.T) {
+	testSet := []struct {
+		prev, cur uint64
+		width     uint
+		expected  uint64
+	}{
+		{10, 15, 64, 5},
+		{10, 15, 32, 5},
+		// 32-bit wraparound: counter rolled over past 2^32
+		{1<<32 - 5, 5, 32, 10},
+		// 64-bit wraparound
+		{1<<64 - 5, 5, 64, 10},
+	}
+	for _, tc := range testSet {
+		if got := counterDelta(tc.prev, tc.cur, tc.width); got != tc.expected {
+			t.Errorf("counterDelta(%d, %d, %d) = %d, expected %d", tc.prev, tc.cur, tc.width, got, tc.expected)
+		}
+	}
+}
+
+func TestRollingAvg(t *testing.T) {
+	r := newRollingAvg(3)
+
+	if avg := r.add(10); avg != 10 {
+		t.Errorf("add(10) on empty window returned %v, expected 10", avg)
+	}
+	if avg := r.add(20); avg != 15 {
+		t.Errorf("add(20) returned %v, expected 15", avg)
+	}
+	if avg := r.add(30); avg != 20 {
+		t.Errorf("add(30) returned %v, expected 20", avg)
+	}
+	// Window is now full; the oldest value (10) should be evicted.
+	if avg := r.add(60); avg != 110.0/3.0 {
+		t.Errorf("add(60) returned %v, expected %v", avg, 110.0/3.0)
+	}
+}
+
+func TestFormatAnnotationLabels(t *testing.T) {
+	if s := formatAnnotationLabels(nil); s != "" {
+		t.Errorf("formatAnnotationLabels(nil) = %q, expected empty string", s)
+	}
+
+	got := formatAnnotationLabels(map[string]string{"pod-name": "nginx", "a_key": "a_value"})
+	expected := `,a_key="a_value",pod_name="nginx"`
+	if got != expected {
+		t.Errorf("formatAnnotationLabels() = %q, expected %q", got, expected)
+	}
+}
+
+func TestSanitizeLabelName(t *testing.T) {
+	testSet := map[string]string{
+		"pod-name":      "pod_name",
+		"io.kubernetes": "io_kubernetes",
+		"1leading":      "_leading",
+		"already_valid": "already_valid",
+	}
+	for in, expected := range testSet {
+		if got := sanitizeLabelName(in); got != expected {
+			t.Errorf("sanitizeLabelName(%q) = %q, expected %q", in, got, expected)
+		}
+	}
+}
@@ -0,0 +1,394 @@
+/*
+Copyright 2019 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdt
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Config is the parsed representation of the RDT YAML configuration.
+type Config struct {
+	Options    Options              `json:"config,omitempty"`
+	Partitions map[string]Partition `json:"partitions,omitempty"`
+}
+
+// Options holds the top-level, resource-wide configuration knobs.
+type Options struct {
+	L3 CatOptions `json:"l3,omitempty"`
+	MB MbaOptions `json:"mb,omitempty"`
+}
+
+// CatOptions configures L3 cache allocation (CAT) handling.
+type CatOptions struct {
+	// Optional, if true, allows L3 CAT to be unavailable without that
+	// being a configuration error.
+	Optional bool `json:"optional,omitempty"`
+}
+
+// MbaOptions configures memory bandwidth allocation (MBA) handling.
+type MbaOptions struct {
+	// Optional, if true, allows MBA to be unavailable without that being a
+	// configuration error.
+	Optional bool `json:"optional,omitempty"`
+}
+
+// Partition is a named share of the total L3 cache and memory bandwidth,
+// subdivided further into Classes.
+type Partition struct {
+	L3Allocation AllocationSet    `json:"l3Allocation,omitempty"`
+	MBAllocation AllocationSet    `json:"mbAllocation,omitempty"`
+	Classes      map[string]Class `json:"classes,omitempty"`
+}
+
+// Class is an RDT class (ctrl group): its L3/MB schema is expressed as its
+// share of the Partition it belongs to.
+type Class struct {
+	L3Schema AllocationSet `json:"l3schema,omitempty"`
+	MBSchema AllocationSet `json:"mbschema,omitempty"`
+}
+
+// AllocationSet maps a cache id (or the special key "all") to an
+// Allocation.
+type AllocationSet map[string]Allocation
+
+// Allocation is the allocation specified for one cache id: a percentage
+// (e.g. "60%") for L3, or one or more percentages/absolute figures for MB,
+// where hardware with mba_MBps support accepts both in the same entry.
+// Accepts either a bare scalar or a list in YAML/JSON.
+type Allocation []string
+
+// UnmarshalJSON accepts both a single scalar string and a list of strings,
+// normalizing either into a non-empty []string.
+func (a *Allocation) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*a = list
+		return nil
+	}
+
+	var single string
+	if err := json.Unmarshal(data, &single); err != nil {
+		return fmt.Errorf("allocation must be a string or a list of strings: %w", err)
+	}
+	*a = []string{single}
+	return nil
+}
+
+// percentage returns the allocation under key as a fraction (e.g. "60%" ->
+// 0.6), using only the first element if the allocation is a list.
+func (a AllocationSet) percentage(key string) (float64, error) {
+	alloc, ok := a[key]
+	if !ok || len(alloc) == 0 {
+		return 0, fmt.Errorf("no %q allocation specified", key)
+	}
+	return parsePercentage(alloc[0])
+}
+
+func parsePercentage(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasSuffix(s, "%") {
+		return 0, fmt.Errorf("invalid percentage %q", s)
+	}
+	v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid percentage %q: %w", s, err)
+	}
+	return v / 100, nil
+}
+
+// SetConfig applies conf: for every configured class it computes an L3/MB
+// schema from its partition's share of the cache/bandwidth, writes it to
+// that class's ctrl group (creating the group first if it doesn't exist
+// yet), and removes ctrl groups that are no longer configured, moving
+// their pids to SYSTEM_DEFAULT first.
+func SetConfig(conf *Config) error {
+	rdt.mu.Lock()
+	defer rdt.mu.Unlock()
+
+	if !rdt.initialized {
+		return fmt.Errorf("rdt not initialized")
+	}
+
+	var totalL3Bits uint
+	l3Available := true
+	cbm, err := readCbmMask()
+	if err != nil {
+		if !conf.Options.L3.Optional {
+			return fmt.Errorf("failed to read L3 cbm_mask: %w", err)
+		}
+		l3Available = false
+	} else {
+		totalL3Bits = uint(bits.Len64(uint64(cbm)))
+	}
+
+	mbAvailable := mbaSupported()
+	if !mbAvailable && !conf.Options.MB.Optional {
+		return fmt.Errorf("MBA not supported by this system")
+	}
+
+	schemata, err := computeSchemata(conf, totalL3Bits, l3Available, mbAvailable)
+	if err != nil {
+		return fmt.Errorf("invalid rdt config: %w", err)
+	}
+
+	for name, cg := range rdt.classes {
+		if name == RootClassName {
+			continue
+		}
+		if _, ok := schemata[name]; ok {
+			continue
+		}
+		if err := evacuateAndRemoveClass(cg); err != nil {
+			return fmt.Errorf("failed to remove class %q: %w", name, err)
+		}
+		delete(rdt.classes, name)
+	}
+
+	for name, schema := range schemata {
+		cg, ok := rdt.classes[name]
+		if !ok {
+			cg = &ctrlGroup{name: name, dirName: rdt.groupPrefix + name, monGroups: make(map[string]*monGroup)}
+			if err := fs.MkdirAll(cg.path(), 0755); err != nil {
+				return fmt.Errorf("failed to create class %q: %w", name, err)
+			}
+			rdt.classes[name] = cg
+		}
+		if err := fs.WriteFile(cg.path("schemata"), []byte(schema), 0644); err != nil {
+			return fmt.Errorf("failed to write schemata of class %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// evacuateAndRemoveClass moves every pid currently in cg to SYSTEM_DEFAULT
+// and then removes cg's ctrl group directory.
+func evacuateAndRemoveClass(cg *ctrlGroup) error {
+	pids, err := getPids(cg.path("tasks"))
+	if err == nil && len(pids) > 0 {
+		if err := addPids(rdt.classes[RootClassName].path("tasks"), pids...); err != nil {
+			return fmt.Errorf("failed to move pids to %s: %w", RootClassName, err)
+		}
+	}
+	return groupRemoveFunc(cg.path())
+}
+
+// computeSchemata turns conf's partitions and classes into a resctrl
+// schemata file content per class name. Partitions are allotted
+// non-overlapping, contiguous L3 cache ways in sorted name order; each
+// class then gets a contiguous share of its partition's ways, from the low
+// end of the partition's range. The L3 and/or MB line is omitted entirely
+// when the corresponding resource isn't available (l3Available/mbAvailable
+// false), so an optional, missing resource doesn't need a percentage in
+// conf at all.
+func computeSchemata(conf *Config, totalL3Bits uint, l3Available, mbAvailable bool) (map[string]string, error) {
+	ids, err := domainIDs(l3Available, mbAvailable)
+	if err != nil {
+		return nil, err
+	}
+
+	schemata := make(map[string]string)
+	var partitionOffset uint
+
+	pnames := make([]string, 0, len(conf.Partitions))
+	for n := range conf.Partitions {
+		pnames = append(pnames, n)
+	}
+	sort.Strings(pnames)
+
+	for _, pname := range pnames {
+		part := conf.Partitions[pname]
+
+		var l3Pct, mbPct float64
+		if l3Available {
+			if l3Pct, err = part.L3Allocation.percentage("all"); err != nil {
+				return nil, fmt.Errorf("partition %q: %w", pname, err)
+			}
+		}
+		if mbAvailable {
+			if mbPct, err = part.MBAllocation.percentage("all"); err != nil {
+				return nil, fmt.Errorf("partition %q: %w", pname, err)
+			}
+		}
+
+		var partitionBits, partitionStart uint
+		if l3Available {
+			partitionBits = uint(math.Round(float64(totalL3Bits) * l3Pct))
+			if partitionBits == 0 {
+				partitionBits = 1
+			}
+			partitionStart = partitionOffset
+			partitionOffset += partitionBits
+		}
+
+		cnames := make([]string, 0, len(part.Classes))
+		for n := range part.Classes {
+			cnames = append(cnames, n)
+		}
+		sort.Strings(cnames)
+
+		for _, cname := range cnames {
+			cls := part.Classes[cname]
+
+			var clsMask Bitmask
+			if l3Available {
+				clsL3Pct, err := cls.L3Schema.percentage("all")
+				if err != nil {
+					return nil, fmt.Errorf("class %q: %w", cname, err)
+				}
+				// Round the class's share of the partition's ways up,
+				// counting in hundredths of a percent to keep float error
+				// out of it: 66% of 8 ways is 6 ways.
+				clsBits := (uint(math.Round(clsL3Pct*10000))*partitionBits + 9999) / 10000
+				if clsBits == 0 {
+					clsBits = 1
+				}
+				if clsBits > partitionBits {
+					clsBits = partitionBits
+				}
+				clsMask = ((Bitmask(1) << clsBits) - 1) << partitionStart
+			}
+
+			clsMBPct := mbPct
+			if mbAvailable {
+				if pct, err := cls.MBSchema.percentage("all"); err == nil {
+					clsMBPct = pct
+				}
+			}
+
+			schemata[cname] = formatSchema(ids, clsMask, clsMBPct, l3Available, mbAvailable)
+		}
+	}
+
+	return schemata, nil
+}
+
+// formatSchema renders a resctrl schemata file giving every cache/domain id
+// in ids the same L3 cache-ways bitmask and memory-bandwidth percentage,
+// omitting the L3 or MB line entirely when that resource isn't available.
+func formatSchema(ids []int, l3Mask Bitmask, mbPct float64, l3Available, mbAvailable bool) string {
+	var lines []string
+	if l3Available {
+		parts := make([]string, 0, len(ids))
+		for _, id := range ids {
+			parts = append(parts, fmt.Sprintf("%d=%x", id, uint64(l3Mask)))
+		}
+		lines = append(lines, "L3:"+strings.Join(parts, ";"))
+	}
+	if mbAvailable {
+		parts := make([]string, 0, len(ids))
+		for _, id := range ids {
+			parts = append(parts, fmt.Sprintf("%d=%d", id, int(math.Round(mbPct*100))))
+		}
+		lines = append(lines, "MB:"+strings.Join(parts, ";"))
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// mbaSupported reports whether the resctrl filesystem advertises MBA
+// support, i.e. has an info/MB directory.
+func mbaSupported() bool {
+	_, err := fs.Stat(filepath.Join(rdt.resctrlPath, "info", "MB"))
+	return err == nil
+}
+
+// readCbmMask reads the maximum L3 cache capacity bitmask advertised by the
+// resctrl filesystem, used to learn how many cache ways there are to
+// partition.
+func readCbmMask() (Bitmask, error) {
+	data, err := fs.ReadFile(filepath.Join(rdt.resctrlPath, "info", "L3", "cbm_mask"))
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(data)), 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cbm_mask %q: %w", strings.TrimSpace(string(data)), err)
+	}
+	return Bitmask(v), nil
+}
+
+// domainIDs returns the cache/domain ids present in the resctrl filesystem,
+// preferring the L3 ids (since L3 and MB domains coincide on every known
+// platform) and falling back to the MB ids when L3 isn't available.
+func domainIDs(l3Available, mbAvailable bool) ([]int, error) {
+	if l3Available {
+		return l3DomainIDs()
+	}
+	if mbAvailable {
+		return mbDomainIDs()
+	}
+	return nil, nil
+}
+
+// l3DomainIDs returns the L3 cache ids present in the resctrl filesystem,
+// discovered from SYSTEM_DEFAULT's own schemata file, which always has one
+// entry per domain for every resource that is present.
+func l3DomainIDs() ([]int, error) {
+	data, err := fs.ReadFile(rdt.classes[RootClassName].path("schemata"))
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "L3:") {
+			continue
+		}
+		return parseSchemaIDs(strings.TrimPrefix(line, "L3:")), nil
+	}
+	return nil, fmt.Errorf("no L3 schema found in %s's schemata", RootClassName)
+}
+
+// mbDomainIDs returns the MB domain ids present in the resctrl filesystem,
+// discovered from SYSTEM_DEFAULT's own schemata file, for use when L3 isn't
+// available but MBA is.
+func mbDomainIDs() ([]int, error) {
+	data, err := fs.ReadFile(rdt.classes[RootClassName].path("schemata"))
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "MB:") {
+			continue
+		}
+		return parseSchemaIDs(strings.TrimPrefix(line, "MB:")), nil
+	}
+	return nil, fmt.Errorf("no MB schema found in %s's schemata", RootClassName)
+}
+
+func parseSchemaIDs(s string) []int {
+	var ids []int
+	for _, entry := range strings.Split(s, ";") {
+		idStr := entry
+		if i := strings.IndexByte(entry, '='); i >= 0 {
+			idStr = entry[:i]
+		}
+		if id, err := strconv.Atoi(strings.TrimSpace(idStr)); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2020 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdt
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// File is the subset of *os.File that a resctrlFS implementation needs to
+// hand back from Open/OpenFile.
+type File interface {
+	io.Reader
+	io.Writer
+	io.Closer
+}
+
+// resctrlFS abstracts all filesystem access needed to drive the resctrl
+// pseudo-filesystem (schemata, tasks and mon_groups files, mountinfo
+// parsing). It exists so that the OS-backed implementation can be swapped
+// for an in-memory one, e.g. to run the test suite without touching disk,
+// or for alternative backends such as a dry-run mode that captures writes
+// instead of applying them to /sys/fs/resctrl.
+type resctrlFS interface {
+	Open(name string) (File, error)
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Stat(name string) (os.FileInfo, error)
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm os.FileMode) error
+	MkdirAll(path string, perm os.FileMode) error
+	RemoveAll(path string) error
+	ReadDir(name string) ([]os.FileInfo, error)
+}
+
+// osFS is the default resctrlFS implementation, backed directly by the OS.
+type osFS struct{}
+
+func (osFS) Open(name string) (File, error) { return os.Open(name) }
+
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (osFS) Stat(name string) (os.FileInfo, error) { return os.Stat(name) }
+
+func (osFS) ReadFile(name string) ([]byte, error) { return ioutil.ReadFile(name) }
+
+func (osFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return ioutil.WriteFile(name, data, perm)
+}
+
+func (osFS) MkdirAll(path string, perm os.FileMode) error { return os.MkdirAll(path, perm) }
+
+func (osFS) RemoveAll(path string) error { return os.RemoveAll(path) }
+
+func (osFS) ReadDir(name string) ([]os.FileInfo, error) { return ioutil.ReadDir(name) }
+
+// fs is the filesystem implementation used for all resctrl I/O done by this
+// package. It defaults to the real OS filesystem.
+var fs resctrlFS = osFS{}
+
+// SetFilesystem sets the filesystem implementation that this package uses
+// for all resctrl I/O, analogous to SetLogger. It is mainly intended for
+// testing, but also allows library users to mount alternative backends,
+// e.g. one that records writes for a dry-run mode instead of applying them.
+func SetFilesystem(f resctrlFS) {
+	fs = f
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2019 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdt
+
+import stdlog "log"
+
+// Logger is the logging interface that this package logs through. SetLogger
+// lets callers plug in their own implementation instead of the default one
+// backed by the standard library's log package.
+type Logger interface {
+	Printf(format string, args ...interface{})
+	Debugf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// logger adapts a standard library *log.Logger to the Logger interface.
+type logger struct {
+	*stdlog.Logger
+}
+
+// NewLoggerWrapper wraps a standard library *log.Logger so that it can be
+// passed to SetLogger.
+func NewLoggerWrapper(l *stdlog.Logger) Logger {
+	return &logger{l}
+}
+
+func (l *logger) Debugf(format string, args ...interface{}) { l.Printf(format, args...) }
+func (l *logger) Errorf(format string, args ...interface{}) { l.Printf(format, args...) }
+
+// SetLogger sets the Logger implementation that this package logs through.
+func SetLogger(l Logger) {
+	rdt.Logger = l
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2019 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdt
+
+import (
+	"strconv"
+	"strings"
+)
+
+// MonLeafData holds the monitoring counters of a single resctrl mon_data
+// leaf directory (e.g. llc_occupancy, mbm_local_bytes, mbm_total_bytes).
+type MonLeafData map[string]uint64
+
+// MonL3Data holds per-L3-cache-id MonLeafData.
+type MonL3Data map[int]MonLeafData
+
+// MonData is one reading of a monitoring group's mon_data directory.
+type MonData struct {
+	L3 MonL3Data
+}
+
+// GetMonData reads the current monitoring data of m's mon_data directory.
+func (m *monGroup) GetMonData() MonData {
+	data := MonData{L3: make(MonL3Data)}
+
+	entries, err := fs.ReadDir(m.path("mon_data"))
+	if err != nil {
+		rdt.Logger.Errorf("failed to read mon_data of mon group %q: %v", m.name, err)
+		return data
+	}
+
+	for _, e := range entries {
+		id, ok := parseL3MonDirName(e.Name())
+		if !ok {
+			continue
+		}
+
+		leaf := make(MonLeafData)
+		for _, counter := range []string{"llc_occupancy", "mbm_local_bytes", "mbm_total_bytes"} {
+			raw, err := fs.ReadFile(m.path("mon_data", e.Name(), counter))
+			if err != nil {
+				continue
+			}
+			v, err := strconv.ParseUint(strings.TrimSpace(string(raw)), 10, 64)
+			if err != nil {
+				continue
+			}
+			leaf[counter] = v
+		}
+		data.L3[id] = leaf
+	}
+
+	return data
+}
+
+// parseL3MonDirName extracts the L3 cache id from a mon_data leaf
+// directory name, e.g. "mon_L3_02" -> 2.
+func parseL3MonDirName(name string) (int, bool) {
+	const prefix = "mon_L3_"
+	if !strings.HasPrefix(name, prefix) {
+		return 0, false
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(name, prefix))
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
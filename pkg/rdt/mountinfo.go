@@ -0,0 +1,51 @@
+/*
+Copyright 2019 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdt
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+)
+
+// mountInfoPath is the /proc/mounts-style file that resctrlMountPoint
+// parses to locate the resctrl filesystem. It's a variable so tests can
+// point it at a mock file.
+var mountInfoPath = "/proc/mounts"
+
+// resctrlMountPoint returns the mountpoint of the resctrl filesystem, read
+// from mountInfoPath (fields: device, mountpoint, fstype, options, dump,
+// pass, one mount per line, same layout as /proc/mounts).
+func resctrlMountPoint() (string, error) {
+	f, err := fs.Open(mountInfoPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %q: %w", mountInfoPath, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 3 && fields[2] == "resctrl" {
+			return fields[1], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to parse %q: %w", mountInfoPath, err)
+	}
+	return "", fmt.Errorf("resctrl filesystem not mounted (checked %q)", mountInfoPath)
+}
@@ -0,0 +1,411 @@
+/*
+Copyright 2019 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdt
+
+import (
+	"errors"
+	"fmt"
+	stdlog "log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RootClassName is the name under which the root (unpartitioned) resctrl
+// ctrl group is exposed, mirroring the fact that it has no class directory
+// of its own: every task not explicitly assigned to another class lives
+// here.
+const RootClassName = "SYSTEM_DEFAULT"
+
+// control holds the package's process-wide view of the resctrl filesystem,
+// populated by Initialize and kept up to date by SetConfig/WatchConfig.
+type control struct {
+	mu sync.Mutex
+
+	initialized bool
+	resctrlPath string
+	groupPrefix string
+	classes     map[string]*ctrlGroup
+
+	monSupported bool
+	monFeatures  []string
+
+	Logger Logger
+}
+
+var rdt = &control{Logger: NewLoggerWrapper(stdlog.New(os.Stderr, "[ rdt ] ", 0))}
+
+// CtrlGroup is a handle to one RDT class (a resctrl ctrl group): a set of
+// tasks sharing one cache/memory-bandwidth allocation.
+type CtrlGroup interface {
+	// Name returns the class's name.
+	Name() string
+	// AddPids assigns pids to this class.
+	AddPids(pids ...string) error
+	// GetPids returns the pids currently assigned to this class.
+	GetPids() ([]string, error)
+	// GetMonGroups returns the monitoring groups created under this class.
+	GetMonGroups() []MonGroup
+	// GetMonGroup looks up a monitoring group of this class by name.
+	GetMonGroup(name string) (MonGroup, bool)
+	// CreateMonGroup creates a new monitoring group under this class.
+	CreateMonGroup(name string, annotations map[string]string) (MonGroup, error)
+	// DeleteMonGroup removes a monitoring group created with CreateMonGroup.
+	DeleteMonGroup(name string) error
+}
+
+// MonGroup is a handle to one resctrl monitoring group: a subset of a
+// class's tasks whose cache occupancy/memory bandwidth is tracked
+// separately from the rest of the class.
+type MonGroup interface {
+	// Name returns the monitoring group's name.
+	Name() string
+	// GetAnnotations returns the annotations the group was created with.
+	GetAnnotations() map[string]string
+	// Parent returns the class this monitoring group was created under.
+	Parent() CtrlGroup
+	// AddPids assigns pids to this monitoring group (and, implicitly, to
+	// its parent class).
+	AddPids(pids ...string) error
+	// GetPids returns the pids currently assigned to this monitoring group.
+	GetPids() ([]string, error)
+	// GetMonData returns the group's current monitoring counters.
+	GetMonData() MonData
+}
+
+// ctrlGroup is the concrete implementation of CtrlGroup.
+type ctrlGroup struct {
+	name      string // logical class name, e.g. "Guaranteed"
+	dirName   string // on-disk directory name, e.g. "goresctrl.Guaranteed"; "" for the root group
+	monGroups map[string]*monGroup
+}
+
+// monGroup is the concrete implementation of MonGroup.
+type monGroup struct {
+	name        string // logical group name, e.g. "test_group"
+	dirName     string // on-disk directory name, e.g. "goresctrl.test_group"
+	parent      *ctrlGroup
+	annotations map[string]string
+}
+
+func (cg *ctrlGroup) Name() string { return cg.name }
+
+// path returns the absolute path of elem under cg's ctrl group directory.
+func (cg *ctrlGroup) path(elem ...string) string {
+	return filepath.Join(append([]string{rdt.resctrlPath, cg.dirName}, elem...)...)
+}
+
+// relPath returns the path of elem under cg's ctrl group directory, relative
+// to the resctrl mountpoint.
+func (cg *ctrlGroup) relPath(elem ...string) string {
+	return filepath.Join(append([]string{cg.dirName}, elem...)...)
+}
+
+func (cg *ctrlGroup) AddPids(pids ...string) error {
+	return addPids(cg.path("tasks"), pids...)
+}
+
+func (cg *ctrlGroup) GetPids() ([]string, error) {
+	return getPids(cg.path("tasks"))
+}
+
+func (cg *ctrlGroup) GetMonGroups() []MonGroup {
+	rdt.mu.Lock()
+	defer rdt.mu.Unlock()
+
+	names := make([]string, 0, len(cg.monGroups))
+	for n := range cg.monGroups {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	out := make([]MonGroup, 0, len(names))
+	for _, n := range names {
+		out = append(out, cg.monGroups[n])
+	}
+	return out
+}
+
+func (cg *ctrlGroup) GetMonGroup(name string) (MonGroup, bool) {
+	rdt.mu.Lock()
+	defer rdt.mu.Unlock()
+
+	mg, ok := cg.monGroups[name]
+	if !ok {
+		return nil, false
+	}
+	return mg, true
+}
+
+func (cg *ctrlGroup) CreateMonGroup(name string, annotations map[string]string) (MonGroup, error) {
+	rdt.mu.Lock()
+	defer rdt.mu.Unlock()
+
+	mg := &monGroup{
+		name:        name,
+		dirName:     rdt.groupPrefix + name,
+		parent:      cg,
+		annotations: annotations,
+	}
+	if err := fs.MkdirAll(mg.path(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create monitor group %q: %w", name, err)
+	}
+	cg.monGroups[name] = mg
+	return mg, nil
+}
+
+func (cg *ctrlGroup) DeleteMonGroup(name string) error {
+	rdt.mu.Lock()
+	defer rdt.mu.Unlock()
+
+	mg, ok := cg.monGroups[name]
+	if !ok {
+		return fmt.Errorf("unknown monitor group %q", name)
+	}
+	if err := groupRemoveFunc(mg.path()); err != nil {
+		return fmt.Errorf("failed to remove monitor group %q: %w", name, err)
+	}
+	delete(cg.monGroups, name)
+	return nil
+}
+
+func (mg *monGroup) Name() string                     { return mg.name }
+func (mg *monGroup) GetAnnotations() map[string]string { return mg.annotations }
+func (mg *monGroup) Parent() CtrlGroup                 { return mg.parent }
+
+// path returns the absolute path of elem under mg's monitor group directory.
+func (mg *monGroup) path(elem ...string) string {
+	return filepath.Join(append([]string{mg.parent.path(), "mon_groups", mg.dirName}, elem...)...)
+}
+
+// relPath returns the path of elem under mg's monitor group directory,
+// relative to the resctrl mountpoint.
+func (mg *monGroup) relPath(elem ...string) string {
+	return filepath.Join(append([]string{mg.parent.relPath(), "mon_groups", mg.dirName}, elem...)...)
+}
+
+func (mg *monGroup) AddPids(pids ...string) error {
+	return addPids(mg.path("tasks"), pids...)
+}
+
+func (mg *monGroup) GetPids() ([]string, error) {
+	return getPids(mg.path("tasks"))
+}
+
+// addPids assigns pids to the resctrl group owning tasksPath. Real resctrl
+// only accepts one pid per write(2), so each pid is written individually
+// rather than joining them into a single write.
+func addPids(tasksPath string, pids ...string) error {
+	f, err := fs.OpenFile(tasksPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %q: %w", tasksPath, err)
+	}
+	defer f.Close()
+
+	for _, pid := range pids {
+		if _, err := f.Write([]byte(pid + "\n")); err != nil {
+			return fmt.Errorf("failed to assign pid %q via %q: %w", pid, tasksPath, err)
+		}
+	}
+	return nil
+}
+
+// getPids returns the pids listed in tasksPath.
+func getPids(tasksPath string) ([]string, error) {
+	data, err := fs.ReadFile(tasksPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q: %w", tasksPath, err)
+	}
+	return splitLines(data), nil
+}
+
+// groupRemoveFunc removes the ctrl/monitor group directory at path. It is a
+// variable so that tests can swap in a mock implementation.
+var groupRemoveFunc = removeGroupDir
+
+// removeGroupDir removes a resctrl group directory, retrying for a while on
+// EBUSY: the kernel refuses to remove a group while one of its tasks hasn't
+// yet been fully reaped from it.
+func removeGroupDir(path string) error {
+	var err error
+	for i := 0; i < 10; i++ {
+		err = fs.RemoveAll(path)
+		if err == nil || !errors.Is(err, syscall.EBUSY) {
+			return err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return err
+}
+
+// Initialize sets up the package to manage the resctrl filesystem found on
+// this system, reading its current classes and monitor groups. groupPrefix
+// is prepended to the directory name of every ctrl/monitor group this
+// package creates, so that goresctrl-managed groups can be told apart from
+// ones created by other agents on the same system.
+func Initialize(groupPrefix string) error {
+	rdt.mu.Lock()
+	defer rdt.mu.Unlock()
+
+	mountpoint, err := resctrlMountPoint()
+	if err != nil {
+		return fmt.Errorf("failed to locate resctrl filesystem: %w", err)
+	}
+
+	classes := map[string]*ctrlGroup{
+		RootClassName: {name: RootClassName, monGroups: make(map[string]*monGroup)},
+	}
+
+	entries, err := fs.ReadDir(mountpoint)
+	if err != nil {
+		return fmt.Errorf("failed to read resctrl filesystem %q: %w", mountpoint, err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() == "info" || e.Name() == "mon_groups" {
+			continue
+		}
+		name := trimGroupPrefix(e.Name(), groupPrefix)
+		classes[name] = &ctrlGroup{name: name, dirName: e.Name(), monGroups: make(map[string]*monGroup)}
+	}
+
+	for _, cg := range classes {
+		if err := discoverMonGroups(cg, mountpoint, groupPrefix); err != nil {
+			return fmt.Errorf("failed to read monitor groups of class %q: %w", cg.name, err)
+		}
+	}
+
+	monFeatures, monSupported := discoverMonFeatures(mountpoint)
+
+	rdt.resctrlPath = mountpoint
+	rdt.groupPrefix = groupPrefix
+	rdt.classes = classes
+	rdt.monSupported = monSupported
+	rdt.monFeatures = monFeatures
+	rdt.initialized = true
+
+	return nil
+}
+
+// discoverMonGroups populates cg.monGroups from the monitor group
+// directories found under cg's ctrl group, if any.
+func discoverMonGroups(cg *ctrlGroup, mountpoint, groupPrefix string) error {
+	entries, err := fs.ReadDir(filepath.Join(mountpoint, cg.dirName, "mon_groups"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		name := trimGroupPrefix(e.Name(), groupPrefix)
+		cg.monGroups[name] = &monGroup{name: name, dirName: e.Name(), parent: cg}
+	}
+	return nil
+}
+
+// discoverMonFeatures reads the set of monitoring features (e.g.
+// llc_occupancy, mbm_total_bytes, mbm_local_bytes) advertised by the
+// resctrl filesystem's L3 monitoring info directory, which only exists when
+// the hardware/kernel support L3 monitoring (L3_MON).
+func discoverMonFeatures(mountpoint string) ([]string, bool) {
+	data, err := fs.ReadFile(filepath.Join(mountpoint, "info", "L3_MON", "mon_features"))
+	if err != nil {
+		return nil, false
+	}
+	return splitLines(data), true
+}
+
+// trimGroupPrefix strips groupPrefix from dirName if present, so that a
+// group created by us is exposed under its original logical name; group
+// directories created by other agents, which don't carry our prefix, are
+// exposed under their on-disk name as-is.
+func trimGroupPrefix(dirName, groupPrefix string) string {
+	if groupPrefix == "" {
+		return dirName
+	}
+	if name := stripPrefix(dirName, groupPrefix); name != "" {
+		return name
+	}
+	return dirName
+}
+
+func stripPrefix(s, prefix string) string {
+	if len(s) > len(prefix) && s[:len(prefix)] == prefix {
+		return s[len(prefix):]
+	}
+	return ""
+}
+
+// GetClasses returns all RDT classes known to the package, sorted by name.
+// It returns an empty slice if the package has not been Initialized.
+func GetClasses() []CtrlGroup {
+	rdt.mu.Lock()
+	defer rdt.mu.Unlock()
+
+	if !rdt.initialized {
+		return nil
+	}
+
+	names := make([]string, 0, len(rdt.classes))
+	for n := range rdt.classes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	out := make([]CtrlGroup, 0, len(names))
+	for _, n := range names {
+		out = append(out, rdt.classes[n])
+	}
+	return out
+}
+
+// GetClass looks up an RDT class by name.
+func GetClass(name string) (CtrlGroup, bool) {
+	rdt.mu.Lock()
+	defer rdt.mu.Unlock()
+
+	if !rdt.initialized {
+		return nil, false
+	}
+	cg, ok := rdt.classes[name]
+	if !ok {
+		return nil, false
+	}
+	return cg, true
+}
+
+// MonSupported returns true if the resctrl filesystem supports L3
+// monitoring.
+func MonSupported() bool {
+	rdt.mu.Lock()
+	defer rdt.mu.Unlock()
+	return rdt.monSupported
+}
+
+// GetMonFeatures returns the L3 monitoring features supported by the
+// resctrl filesystem, e.g. "llc_occupancy", "mbm_total_bytes".
+func GetMonFeatures() []string {
+	rdt.mu.Lock()
+	defer rdt.mu.Unlock()
+	return rdt.monFeatures
+}
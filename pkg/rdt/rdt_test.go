@@ -17,14 +17,16 @@ limitations under the License.
 package rdt
 
 import (
-	"io/ioutil"
+	"bytes"
 	stdlog "log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"sigs.k8s.io/yaml"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 
@@ -34,22 +36,25 @@ import (
 
 const mockGroupPrefix string = "goresctrl."
 
+// mockResctrlFs sets up a mock resctrl filesystem backed by memFS, an
+// in-memory resctrlFS implementation, and installs it with SetFilesystem.
+// This lets TestRdt run without exec, without writing to disk (fixtures are
+// only read from test/data), and deterministically on any OS.
 type mockResctrlFs struct {
 	t *testing.T
 
+	fs      *memFS
 	origDir string
 	baseDir string
 }
 
 func newMockResctrlFs(t *testing.T, name, mountOpts string) (*mockResctrlFs, error) {
-	var err error
-	m := &mockResctrlFs{t: t}
+	m := &mockResctrlFs{t: t, fs: newMemFS()}
 
 	m.origDir = testdata.Path(name)
-	m.baseDir, err = ioutil.TempDir("", "goresctrl.test.")
-	if err != nil {
-		return nil, err
-	}
+	m.baseDir = "/mock-goresctrl"
+
+	SetFilesystem(m.fs)
 
 	// Create resctrl filesystem mock
 	m.copyFromOrig("", "")
@@ -58,44 +63,73 @@ func newMockResctrlFs(t *testing.T, name, mountOpts string) (*mockResctrlFs, err
 	mountInfoPath = filepath.Join(m.baseDir, "mounts")
 	resctrlPath := filepath.Join(m.baseDir, "resctrl")
 	data := "resctrl " + resctrlPath + " resctrl " + mountOpts + " 0 0\n"
-	if err := ioutil.WriteFile(mountInfoPath, []byte(data), 0644); err != nil {
-		m.delete()
+	if err := m.fs.WriteFile(mountInfoPath, []byte(data), 0644); err != nil {
 		return nil, err
 	}
 	return m, nil
 }
 
 func (m *mockResctrlFs) delete() {
-	if err := os.RemoveAll(m.baseDir); err != nil {
-		m.t.Fatalf("failed to delete mock resctrl fs: %v", err)
-	}
+	SetFilesystem(osFS{})
 }
 
 func (m *mockResctrlFs) initMockMonGroup(class, name string) {
 	m.copyFromOrig(filepath.Join("mon_groups", "example"), filepath.Join(mockGroupPrefix+class, "mon_groups", mockGroupPrefix+name))
 }
 
+// copyFromOrig reads fixture files from the real, on-disk test/data tree
+// (relSrc, relative to m.origDir) and loads them into the in-memory mock
+// resctrl fs (relDst, relative to "<baseDir>/resctrl").
 func (m *mockResctrlFs) copyFromOrig(relSrc, relDst string) {
 	absSrc := filepath.Join(m.origDir, relSrc)
-	if s, err := os.Stat(absSrc); err != nil {
+	absDst := filepath.Join(m.baseDir, "resctrl", relDst)
+
+	info, err := os.Stat(absSrc)
+	if err != nil {
 		m.t.Fatalf("%v", err)
-	} else if s.IsDir() {
-		absSrc = filepath.Join(absSrc, ".")
 	}
 
-	absDst := filepath.Join(m.baseDir, "resctrl", relDst)
-	cmd := exec.Command("cp", "-r", absSrc, absDst)
-	if err := cmd.Run(); err != nil {
+	if !info.IsDir() {
+		m.copyFile(absSrc, absDst, info.Mode())
+		return
+	}
+
+	err = filepath.Walk(absSrc, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(absSrc, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(absDst, rel)
+		if info.IsDir() {
+			return m.fs.MkdirAll(dst, info.Mode())
+		}
+		m.copyFile(path, dst, info.Mode())
+		return nil
+	})
+	if err != nil {
 		m.t.Fatalf("failed to copy mock data %q -> %q: %v", absSrc, absDst, err)
 	}
 }
 
+func (m *mockResctrlFs) copyFile(absSrc, absDst string, mode os.FileMode) {
+	data, err := os.ReadFile(absSrc)
+	if err != nil {
+		m.t.Fatalf("failed to read mock data %q: %v", absSrc, err)
+	}
+	if err := m.fs.WriteFile(absDst, data, mode); err != nil {
+		m.t.Fatalf("failed to write mock data %q: %v", absDst, err)
+	}
+}
+
 func (m *mockResctrlFs) verifyTextFile(relPath, content string) {
-	verifyTextFile(m.t, filepath.Join(m.baseDir, "resctrl", relPath), content)
+	verifyTextFile(m.t, m.fs, filepath.Join(m.baseDir, "resctrl", relPath), content)
 }
 
-func verifyTextFile(t *testing.T, path, content string) {
-	data, err := ioutil.ReadFile(path)
+func verifyTextFile(t *testing.T, f resctrlFS, path, content string) {
+	data, err := f.ReadFile(path)
 	if err != nil {
 		t.Errorf("failed to read %q: %v", path, err)
 	}
@@ -104,6 +138,180 @@ func verifyTextFile(t *testing.T, path, content string) {
 	}
 }
 
+// memFS is a minimal in-memory resctrlFS implementation, used to mock the
+// resctrl pseudo-filesystem in tests the way afero's MemMapFs is typically
+// used to mock a real one.
+type memFS struct {
+	mu    sync.Mutex
+	files map[string][]byte
+	dirs  map[string]os.FileMode
+}
+
+func newMemFS() *memFS {
+	return &memFS{
+		files: make(map[string][]byte),
+		dirs:  map[string]os.FileMode{"/": 0755},
+	}
+}
+
+type memFile struct {
+	fs   *memFS
+	name string
+	buf  *bytes.Buffer
+}
+
+func (f *memFile) Read(p []byte) (int, error) { return f.buf.Read(p) }
+
+func (f *memFile) Write(p []byte) (int, error) {
+	n, err := f.buf.Write(p)
+	f.fs.mu.Lock()
+	f.fs.files[f.name] = append([]byte{}, f.buf.Bytes()...)
+	f.fs.mu.Unlock()
+	return n, err
+}
+
+func (f *memFile) Close() error { return nil }
+
+func (m *memFS) Open(name string) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return &memFile{fs: m, name: name, buf: bytes.NewBuffer(append([]byte{}, data...))}, nil
+}
+
+func (m *memFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	m.mu.Lock()
+	data, ok := m.files[name]
+	if !ok && flag&os.O_CREATE == 0 {
+		m.mu.Unlock()
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	m.dirs[filepath.Dir(name)] = 0755
+	buf := bytes.NewBuffer(nil)
+	if flag&os.O_APPEND != 0 && flag&os.O_TRUNC == 0 {
+		buf.Write(data)
+	}
+	m.mu.Unlock()
+	return &memFile{fs: m, name: name, buf: buf}, nil
+}
+
+func (m *memFS) Stat(name string) (os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if data, ok := m.files[name]; ok {
+		return memFileInfo{name: filepath.Base(name), size: int64(len(data))}, nil
+	}
+	if mode, ok := m.dirs[name]; ok {
+		return memFileInfo{name: filepath.Base(name), isDir: true, mode: mode}, nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
+func (m *memFS) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return append([]byte{}, data...), nil
+}
+
+func (m *memFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dirs[filepath.Dir(name)] = 0755
+	m.files[name] = append([]byte{}, data...)
+	return nil
+}
+
+func (m *memFS) MkdirAll(path string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for p := path; p != "." && p != "/" && p != ""; p = filepath.Dir(p) {
+		m.dirs[p] = perm
+	}
+	return nil
+}
+
+func (m *memFS) RemoveAll(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	prefix := path + string(filepath.Separator)
+	for name := range m.files {
+		if name == path || strings.HasPrefix(name, prefix) {
+			delete(m.files, name)
+		}
+	}
+	for name := range m.dirs {
+		if name == path || strings.HasPrefix(name, prefix) {
+			delete(m.dirs, name)
+		}
+	}
+	return nil
+}
+
+func (m *memFS) ReadDir(name string) ([]os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	prefix := name + string(filepath.Separator)
+	seen := make(map[string]os.FileInfo)
+	for f, data := range m.files {
+		if entry, isDir, ok := childEntry(f, prefix); ok {
+			if isDir {
+				seen[entry] = memFileInfo{name: entry, isDir: true}
+			} else {
+				seen[entry] = memFileInfo{name: entry, size: int64(len(data))}
+			}
+		}
+	}
+	for d := range m.dirs {
+		if entry, _, ok := childEntry(d, prefix); ok {
+			seen[entry] = memFileInfo{name: entry, isDir: true}
+		}
+	}
+	entries := make([]os.FileInfo, 0, len(seen))
+	for _, fi := range seen {
+		entries = append(entries, fi)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// childEntry returns the direct child of prefix that path falls under
+// (entry), whether that child is itself a directory because path continues
+// beyond it (isDir), and whether path is located under prefix at all (ok).
+func childEntry(path, prefix string) (entry string, isDir bool, ok bool) {
+	if !strings.HasPrefix(path, prefix) {
+		return "", false, false
+	}
+	rel := strings.TrimPrefix(path, prefix)
+	if rel == "" {
+		return "", false, false
+	}
+	if i := strings.IndexRune(rel, filepath.Separator); i >= 0 {
+		return rel[:i], true, true
+	}
+	return rel, false, true
+}
+
+type memFileInfo struct {
+	name  string
+	size  int64
+	isDir bool
+	mode  os.FileMode
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return i.size }
+func (i memFileInfo) Mode() os.FileMode  { return i.mode }
+func (i memFileInfo) ModTime() time.Time { return time.Time{} }
+func (i memFileInfo) IsDir() bool        { return i.isDir }
+func (i memFileInfo) Sys() interface{}   { return nil }
+
 func parseTestConfig(t *testing.T, data string) *Config {
 	c := &Config{}
 	if err := yaml.Unmarshal([]byte(data), c); err != nil {
@@ -173,7 +381,7 @@ func TestRdt(t *testing.T) {
 	}
 
 	// Set group remove function so that mock groups can be removed
-	groupRemoveFunc = os.RemoveAll
+	groupRemoveFunc = func(path string) error { return fs.RemoveAll(path) }
 
 	//
 	// 1. test uninitialized interface
@@ -249,7 +457,7 @@ func TestRdt(t *testing.T) {
 
 	// Verify that existing goresctrl monitor groups were removed
 	for _, cls := range []string{RootClassName, "Guaranteed"} {
-		files, _ := ioutil.ReadDir(rdt.classes[cls].path("mon_groups"))
+		files, _ := fs.ReadDir(rdt.classes[cls].path("mon_groups"))
 		for _, f := range files {
 			if strings.HasPrefix(mockGroupPrefix, f.Name()) {
 				t.Errorf("unexpected monitor group found %q", f.Name())
@@ -307,7 +515,7 @@ func TestRdt(t *testing.T) {
 	verifyGroupNames(cls.GetMonGroups(), []string{"predefined_group_live", mgName})
 
 	mgPath := rdt.classes["Guaranteed"].path("mon_groups", "goresctrl."+mgName)
-	if _, err := os.Stat(mgPath); err != nil {
+	if _, err := fs.Stat(mgPath); err != nil {
 		t.Errorf("mon group directory not found: %v", err)
 	}
 
@@ -327,7 +535,7 @@ func TestRdt(t *testing.T) {
 	if _, ok := cls.GetMonGroup("non-existing-group"); ok {
 		t.Errorf("unexpected success when querying deleted group")
 	}
-	if _, err := os.Stat(mgPath); !os.IsNotExist(err) {
+	if _, err := fs.Stat(mgPath); !os.IsNotExist(err) {
 		t.Errorf("unexpected error when checking directory of deleted mon group: %v", err)
 	}
 
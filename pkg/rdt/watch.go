@@ -0,0 +1,193 @@
+/*
+Copyright 2021 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdt
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"sigs.k8s.io/yaml"
+)
+
+// ConfigEventType identifies the kind of event emitted on ConfigEvents.
+type ConfigEventType int
+
+const (
+	// ConfigEventReloaded indicates that a changed config file was parsed
+	// and successfully applied.
+	ConfigEventReloaded ConfigEventType = iota
+	// ConfigEventInvalid indicates that a changed config file failed to
+	// parse or apply (the previously applied configuration is left as-is),
+	// or that the fsnotify watcher itself reported an error.
+	ConfigEventInvalid
+)
+
+// ConfigEvent is emitted on the channel returned by ConfigEvents whenever
+// WatchConfig observes and processes a change to the watched config file.
+type ConfigEvent struct {
+	Type ConfigEventType
+	Path string
+	Err  error // non-nil when Type is ConfigEventInvalid
+}
+
+var configEvents = make(chan ConfigEvent, 8)
+
+var configWatchers struct {
+	mu    sync.Mutex
+	byAbs map[string]*fsnotify.Watcher
+}
+
+// ConfigEvents returns the channel on which WatchConfig reports successful
+// reloads and validation failures of the config file(s) it watches.
+func ConfigEvents() <-chan ConfigEvent {
+	return configEvents
+}
+
+// WatchConfig loads the RDT configuration from path, applies it with
+// SetConfig, and then keeps watching path for further changes via fsnotify.
+// Because editors commonly replace a file rather than writing it in place
+// (rename-over-target), the parent directory is watched rather than the
+// file itself. Every subsequent change is re-parsed and re-applied live:
+// SetConfig takes care of recomputing partition/class schemata, creating
+// classes that appeared, and moving the pids of classes that disappeared to
+// SYSTEM_DEFAULT before removing them together with their monitoring
+// groups. Monitoring groups of classes that remain configured are left as
+// they are.
+// Results are reported on the channel returned by ConfigEvents; a config
+// that fails to parse or apply is left un-applied and reported as such.
+// Call UnwatchConfig with the same path to stop watching it.
+func WatchConfig(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve config path %q: %w", path, err)
+	}
+
+	if err := loadAndApplyConfig(absPath); err != nil {
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher for config %q: %w", absPath, err)
+	}
+	if err := watcher.Add(filepath.Dir(absPath)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch directory of config %q: %w", absPath, err)
+	}
+
+	configWatchers.mu.Lock()
+	if configWatchers.byAbs == nil {
+		configWatchers.byAbs = make(map[string]*fsnotify.Watcher)
+	}
+	if old, ok := configWatchers.byAbs[absPath]; ok {
+		old.Close()
+	}
+	configWatchers.byAbs[absPath] = watcher
+	configWatchers.mu.Unlock()
+
+	go watchConfigFile(absPath, watcher)
+	return nil
+}
+
+// UnwatchConfig stops watching path for changes, undoing a previous
+// WatchConfig call for the same path: it closes the underlying fsnotify
+// watcher, which ends that path's watchConfigFile goroutine. Unwatching a
+// path that isn't currently watched is not an error.
+func UnwatchConfig(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve config path %q: %w", path, err)
+	}
+
+	configWatchers.mu.Lock()
+	defer configWatchers.mu.Unlock()
+
+	watcher, ok := configWatchers.byAbs[absPath]
+	if !ok {
+		return nil
+	}
+	delete(configWatchers.byAbs, absPath)
+	return watcher.Close()
+}
+
+// watchConfigFile re-applies the config at path every time fsnotify reports
+// a write, create or rename of it through the watch on its parent directory
+// (which also catches rename-replace edits) until the watcher is closed.
+func watchConfigFile(path string, watcher *fsnotify.Watcher) {
+	dir := filepath.Dir(path)
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != path {
+				continue
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			// A rename-replace drops the inotify watch on the old inode;
+			// re-add the directory watch defensively so we keep seeing
+			// future edits.
+			_ = watcher.Add(dir)
+
+			if err := loadAndApplyConfig(path); err != nil {
+				sendConfigEvent(ConfigEvent{Type: ConfigEventInvalid, Path: path, Err: err})
+				continue
+			}
+			sendConfigEvent(ConfigEvent{Type: ConfigEventReloaded, Path: path})
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			sendConfigEvent(ConfigEvent{Type: ConfigEventInvalid, Path: path, Err: err})
+		}
+	}
+}
+
+// sendConfigEvent publishes ev on configEvents without blocking: if the
+// buffer is full because nothing is draining ConfigEvents(), the event is
+// dropped (mirroring the default: pattern collector.go's collect() uses for
+// its own samples channel) rather than wedging watchConfigFile forever and
+// silently stopping hot-reload for good.
+func sendConfigEvent(ev ConfigEvent) {
+	select {
+	case configEvents <- ev:
+	default:
+		// Don't block the watcher goroutine on a slow or absent reader; the
+		// next event (or the next successful reload) supersedes this one.
+	}
+}
+
+func loadAndApplyConfig(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config %q: %w", path, err)
+	}
+	conf := &Config{}
+	if err := yaml.Unmarshal(data, conf); err != nil {
+		return fmt.Errorf("failed to parse config %q: %w", path, err)
+	}
+	if err := SetConfig(conf); err != nil {
+		return fmt.Errorf("failed to apply config %q: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2021 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdt
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWatchConfig writes successive YAML files into a temp dir and asserts
+// that the schemata files under the mock resctrl fs are updated
+// accordingly, and that an invalid config is reported without being
+// applied.
+func TestWatchConfig(t *testing.T) {
+	mockFs, err := newMockResctrlFs(t, "resctrl.full", "")
+	if err != nil {
+		t.Fatalf("failed to set up mock resctrl fs: %v", err)
+	}
+	defer mockFs.delete()
+
+	if err := Initialize(mockGroupPrefix); err != nil {
+		t.Fatalf("rdt initialization failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	confPath := filepath.Join(dir, "rdt.conf.yaml")
+
+	writeConfig := func(data string) {
+		if err := ioutil.WriteFile(confPath, []byte(data), 0644); err != nil {
+			t.Fatalf("failed to write config %q: %v", confPath, err)
+		}
+	}
+
+	writeConfig(rdtTestConfig)
+	if err := WatchConfig(confPath); err != nil {
+		t.Fatalf("WatchConfig() failed: %v", err)
+	}
+	defer func() {
+		if err := UnwatchConfig(confPath); err != nil {
+			t.Errorf("UnwatchConfig() failed: %v", err)
+		}
+	}()
+
+	schemataPath := filepath.Join(mockFs.baseDir, "resctrl", rdt.classes["Guaranteed"].relPath("schemata"))
+	before, err := mockFs.fs.ReadFile(schemataPath)
+	if err != nil {
+		t.Fatalf("failed to read schemata after initial load: %v", err)
+	}
+
+	// Rewrite the config with a different partition split and wait for the
+	// watcher to pick up the change.
+	updated := strings.Replace(rdtTestConfig, "all: 60%", "all: 50%", 1)
+	writeConfig(updated)
+
+	select {
+	case ev := <-ConfigEvents():
+		if ev.Type != ConfigEventReloaded {
+			t.Fatalf("expected a reload event, got %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for config reload event")
+	}
+
+	after, err := mockFs.fs.ReadFile(schemataPath)
+	if err != nil {
+		t.Fatalf("failed to read schemata after reload: %v", err)
+	}
+	if string(before) == string(after) {
+		t.Errorf("schemata did not change after reloading an updated config")
+	}
+
+	// An invalid config should be reported, and left un-applied.
+	writeConfig("not: valid: yaml: [")
+
+	select {
+	case ev := <-ConfigEvents():
+		if ev.Type != ConfigEventInvalid {
+			t.Fatalf("expected an invalid-config event, got %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for invalid config event")
+	}
+
+	stillApplied, err := mockFs.fs.ReadFile(schemataPath)
+	if err != nil {
+		t.Fatalf("failed to read schemata after invalid config: %v", err)
+	}
+	if string(stillApplied) != string(after) {
+		t.Errorf("schemata changed despite the new config being invalid")
+	}
+}
+
+// TestUnwatchConfigIdempotent verifies that unwatching a path that isn't
+// currently being watched (e.g. because it was already unwatched, or never
+// watched at all) isn't an error.
+func TestUnwatchConfigIdempotent(t *testing.T) {
+	if err := UnwatchConfig(filepath.Join(t.TempDir(), "never-watched.yaml")); err != nil {
+		t.Errorf("UnwatchConfig() on a never-watched path failed: %v", err)
+	}
+}